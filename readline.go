@@ -38,17 +38,100 @@ package readline
 
 #include <stdio.h>
 #include <stdlib.h>
+#include <string.h>
 #include <readline/readline.h>
 #include <readline/history.h>
 
 extern char *_completion_function(char *s, int i);
+extern char **_attempted_completion_function(char *s, int start, int end);
+extern int _cycle_complete(int count, int key);
 
 static char *_completion_function_trans(const char *s, int i) {
 	return _completion_function((char *) s, i);
 }
 
+static char **_attempted_completion_function_trans(const char *s, int start, int end) {
+	return _attempted_completion_function((char *) s, start, end);
+}
+
+static int _cycle_complete_trans(int count, int key) {
+	return _cycle_complete(count, key);
+}
+
+static void set_tab_style(int circular) {
+	if (circular) {
+		rl_bind_key('\t', _cycle_complete_trans);
+	} else {
+		rl_bind_key('\t', rl_complete);
+	}
+}
+
+static rl_voidfunc_t *_saved_redisplay_function;
+
+static void _silent_redisplay() {}
+
+static void install_silent_redisplay() {
+	_saved_redisplay_function = rl_redisplay_function;
+	rl_redisplay_function = _silent_redisplay;
+}
+
+static void restore_redisplay() {
+	rl_redisplay_function = _saved_redisplay_function;
+}
+
+extern int _go_kill_command(int count, int key);
+
+static int _go_kill_command_trans(int count, int key) {
+	return _go_kill_command(count, key);
+}
+
+static void set_kill_ring_tracking(int on) {
+	if (on) {
+		rl_bind_key(0x0b, _go_kill_command_trans); // C-k: kill to end of line
+		rl_bind_key(0x15, _go_kill_command_trans); // C-u: kill from start of line
+		rl_bind_key(0x17, _go_kill_command_trans); // C-w: kill previous word
+	} else {
+		rl_bind_key(0x0b, rl_kill_line);
+		rl_bind_key(0x15, rl_unix_line_discard);
+		rl_bind_key(0x17, rl_unix_word_rubout);
+	}
+}
+
+static void show_message(const char *s) {
+	rl_message("%s", s);
+}
+
+extern int _go_reverse_search(int count, int key);
+
+static int _go_reverse_search_trans(int count, int key) {
+	return _go_reverse_search(count, key);
+}
+
+static void bind_reverse_search_key() {
+	rl_bind_key(0x12, _go_reverse_search_trans); // C-r
+}
+
+extern int _go_getc(FILE *stream);
+
+static int _go_getc_trans(FILE *stream) {
+	return _go_getc(stream);
+}
+
+static rl_getc_func_t *_default_getc_function = rl_getc;
+
+static void install_paste_getc() {
+	_default_getc_function = rl_getc_function;
+	rl_getc_function = _go_getc_trans;
+}
+
+static void restore_getc() {
+	rl_getc_function = _default_getc_function;
+}
+
 static void register_readline() {
 	rl_completion_entry_function = _completion_function_trans;
+	rl_attempted_completion_function = _attempted_completion_function_trans;
+	bind_reverse_search_key();
 	using_history();
 }
 
@@ -56,8 +139,13 @@ static void register_readline() {
 import "C"
 
 import (
+	"fmt"
 	"io"
+	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
 )
@@ -100,9 +188,47 @@ var escapeSeq = regexp.MustCompile(shortEscRegex + "|" + csiRegex)
 // Begin reading lines. If more than one line is required, the continue prompt
 // is used for subsequent lines.
 func NewReader() io.Reader {
+	installResizeHandler()
 	return new(reader)
 }
 
+// OnResize, when set, is called after the terminal is resized while the
+// user is mid-edit, so callers can reflow anything they render around the
+// prompt, such as a status line.
+var OnResize func(cols, rows int)
+
+var resizeOnce sync.Once
+
+// installResizeHandler wires SIGWINCH to OnResize so callers can reflow
+// anything they render around the prompt after a resize. It only installs
+// the handler once, on the first call to NewReader or String.
+//
+// The actual redraw is left to Readline's own SIGWINCH handling
+// (rl_catch_sigwinch, on by default): Readline isn't thread-safe, so calling
+// rl_resize_terminal from this goroutine while readline() blocks reading on
+// another thread would race on its internal line and screen state.
+func installResizeHandler() {
+	resizeOnce.Do(func() {
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				if OnResize != nil {
+					OnResize(TerminalSize())
+				}
+			}
+		}()
+	})
+}
+
+// TerminalSize returns Readline's current idea of the terminal's width and
+// height, in columns and rows.
+func TerminalSize() (cols, rows int) {
+	var c, r C.int
+	C.rl_get_screen_size(&c, &r)
+	return int(c), int(r)
+}
+
 func (r *reader) getLine() error {
 	prompt := Prompt
 	if r.state == readerContinue {
@@ -141,6 +267,7 @@ func (r *reader) Read(buf []byte) (int, error) {
 
 // Read a line with the given prompt.
 func String(prompt string) (string, error) {
+	installResizeHandler()
 	p := C.CString(prompt)
 	rp := C.readline(p)
 	s := C.GoString(rp)
@@ -152,6 +279,38 @@ func String(prompt string) (string, error) {
 	return s, io.EOF
 }
 
+// PasswordPrompt reads a line the same way String does, except that typed
+// characters are never echoed to the terminal and the line is not added to
+// the history. The prompt itself is still drawn, just once, up front; it is
+// only the secret that stays invisible. The C buffer holding the secret is
+// zeroed before it is freed.
+func PasswordPrompt(prompt string) (string, error) {
+	os.Stdout.WriteString(prompt)
+	C.rl_already_prompted = 1
+	defer func() { C.rl_already_prompted = 0 }()
+	p := C.CString(prompt)
+	C.install_silent_redisplay()
+	rp := C.readline(p)
+	C.restore_redisplay()
+	C.free(unsafe.Pointer(p))
+	if rp == nil {
+		return "", io.EOF
+	}
+	s := C.GoString(rp)
+	wipeCString(rp)
+	C.free(unsafe.Pointer(rp))
+	return s, nil
+}
+
+// wipeCString overwrites a NUL-terminated C string with zero bytes.
+func wipeCString(p *C.char) {
+	n := int(C.strlen(p))
+	b := unsafe.Slice((*byte)(unsafe.Pointer(p)), n)
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 // This function provides entries for the tab completer.
 var Completer = func(query, ctx string) []string {
 	return nil
@@ -180,6 +339,317 @@ func SetWordBreaks(cs string) {
 	C.rl_completer_word_break_characters = C.CString(cs)
 }
 
+// WordCompleter, when set, takes over tab completion from Completer. It is
+// called with the full line buffer and the cursor position within it, and
+// returns the portions of the line before and after the word under the
+// cursor (head and tail) along with the candidates for that word, so
+// callers don't need to re-parse rl_line_buffer themselves to find the word
+// being completed. The substitution itself is still performed by Readline
+// at the word boundary its own word-break characters determine (see
+// SetWordBreaks), the same as for Completer. This applies to the TabPrints
+// style only: TabCircular cycling always takes its candidates from Completer.
+var WordCompleter func(line string, pos int) (head string, completions []string, tail string)
+
+//export _attempted_completion_function
+func _attempted_completion_function(text *C.char, from, to C.int) **C.char {
+	if WordCompleter == nil {
+		return nil
+	}
+	line := C.GoString(C.rl_line_buffer)
+	pos := int(C.rl_point)
+	_, completions, _ := WordCompleter(line, pos)
+	C.rl_attempted_completion_over = 1
+	if len(completions) == 0 {
+		return nil
+	}
+	if len(completions) == 1 {
+		C.rl_completion_suppress_append = 1
+	}
+	return newMatches(completions)
+}
+
+// commonPrefix returns the longest string that is a prefix of every entry
+// in ss.
+func commonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	p := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, p) {
+			p = p[:len(p)-1]
+			if p == "" {
+				return ""
+			}
+		}
+	}
+	return p
+}
+
+// newMatches builds a NULL-terminated array of C strings in the format GNU
+// Readline expects to get back from an attempted-completion function:
+// matches[0] is the text Readline substitutes for the word it is
+// completing, and the rest are the individual candidates it lists if the
+// user presses Tab again. Readline takes ownership of the array and its
+// elements and frees them itself.
+func newMatches(completions []string) **C.char {
+	n := len(completions)
+	size := C.size_t(n+2) * C.size_t(unsafe.Sizeof(uintptr(0)))
+	base := C.malloc(size)
+	ptrs := (*[1 << 30]*C.char)(base)[: n+2 : n+2]
+	ptrs[0] = C.CString(commonPrefix(completions))
+	for i, s := range completions {
+		ptrs[i+1] = C.CString(s)
+	}
+	ptrs[n+1] = nil
+	return (**C.char)(base)
+}
+
+// TabStyle selects how repeated presses of Tab behave.
+type TabStyle int
+
+const (
+	// TabPrints is GNU Readline's default: the first Tab press completes
+	// the longest common prefix of the candidates, and a second press on
+	// an unchanged word lists them.
+	TabPrints TabStyle = iota
+	// TabCircular rotates through the candidates in place, replacing the
+	// word under the cursor with the next one on every Tab press, as in
+	// liner and linenoise.
+	TabCircular
+)
+
+// SetTabStyle installs the key binding for Tab matching style.
+func SetTabStyle(style TabStyle) {
+	if style == TabCircular {
+		C.set_tab_style(1)
+	} else {
+		C.set_tab_style(0)
+	}
+}
+
+// tabCycle caches the candidates for the word currently being cycled
+// through, keyed on the (prefix, line, point) they were computed for, so
+// repeated Tab presses rotate through them in O(1).
+type tabCycle struct {
+	line    string
+	point   int
+	prefix  string
+	start   int
+	entries []string
+	index   int
+}
+
+var cycling tabCycle
+
+//export _cycle_complete
+func _cycle_complete(count, key C.int) C.int {
+	line := C.GoString(C.rl_line_buffer)
+	point := int(C.rl_point)
+	if cycling.entries == nil || cycling.line != line || cycling.point != point {
+		start := wordStart(line, point)
+		cycling = tabCycle{
+			line:    line,
+			point:   point,
+			prefix:  line[start:point],
+			start:   start,
+			entries: Completer(line[start:point], line),
+			index:   -1,
+		}
+	}
+	if len(cycling.entries) == 0 {
+		C.rl_ding()
+		return 0
+	}
+	cycling.index = (cycling.index + 1) % len(cycling.entries)
+	next := cycling.entries[cycling.index]
+	C.rl_delete_text(C.int(cycling.start), C.int(cycling.point))
+	C.rl_point = C.int(cycling.start)
+	m := C.CString(next)
+	C.rl_insert_text(m)
+	C.free(unsafe.Pointer(m))
+	cycling.point = cycling.start + len(next)
+	C.rl_point = C.int(cycling.point)
+	cycling.line = C.GoString(C.rl_line_buffer)
+	return 0
+}
+
+// wordStart finds where the word ending at point within line begins, using
+// the same default break characters as GNU Readline.
+func wordStart(line string, point int) int {
+	if point > len(line) {
+		point = len(line)
+	}
+	return strings.LastIndexAny(line[:point], " \t\n\"'`@$><=;|&(){}") + 1
+}
+
+// goKillRing holds recently killed text, most recent first. It is NOT
+// Readline's own kill ring: rl_kill_ring is private to the C library (not
+// part of its public API, and absent from libreadline's exported symbols),
+// so there is no way to read or write it from Go. GoKillRing is a separate
+// ring this package maintains on the side, optionally fed from C-k, C-u and
+// C-w via SetGoKillRingTracking.
+var goKillRing []string
+
+// SetGoKillRingTracking controls whether C-k, C-u and C-w record the text
+// they remove into GoKillRing. It is off by default, so programs that never
+// touch this API keep GNU Readline's exact default bindings; turning it on
+// trades away their native semantics (consecutive kills no longer coalesce
+// into one ring entry, and C-w's word boundary comes from the same fixed
+// break-character set that TabCircular cycling uses, not from SetWordBreaks
+// nor from unix-word-rubout's own rules).
+//
+// Even with tracking on, GoKillRing only ever reflects what was pushed with
+// GoKill or removed by these three keys: kills made through M-d, M-DEL, the
+// region commands, or C-y/M-y go through Readline's own private kill ring
+// instead and never reach it.
+func SetGoKillRingTracking(on bool) {
+	if on {
+		C.set_kill_ring_tracking(1)
+	} else {
+		C.set_kill_ring_tracking(0)
+	}
+}
+
+//export _go_kill_command
+func _go_kill_command(_, key C.int) C.int {
+	point := int(C.rl_point)
+	line := C.GoString(C.rl_line_buffer)
+	var from, to int
+	switch key {
+	case 0x0b: // C-k: kill to end of line
+		from, to = point, len(line)
+	case 0x15: // C-u: kill from start of line
+		from, to = 0, point
+	case 0x17: // C-w: kill previous word
+		from, to = wordStart(line, point), point
+	default:
+		return 0
+	}
+	if from >= to {
+		return 0
+	}
+	cp := C.rl_copy_text(C.int(from), C.int(to))
+	text := C.GoString(cp)
+	C.free(unsafe.Pointer(cp))
+	C.rl_kill_text(C.int(from), C.int(to))
+	GoKill(text)
+	return 0
+}
+
+// GoKillRing returns a snapshot of this package's own kill ring, most
+// recently killed text first. See SetGoKillRingTracking for what it does
+// and doesn't capture; it is not Readline's own kill ring.
+func GoKillRing() []string {
+	cp := make([]string, len(goKillRing))
+	copy(cp, goKillRing)
+	return cp
+}
+
+// GoKill pushes text onto the front of this package's own kill ring, the
+// way C-k, C-u and C-w do for the text they remove from the line when
+// SetGoKillRingTracking is on.
+func GoKill(text string) {
+	goKillRing = append([]string{text}, goKillRing...)
+}
+
+// GoYank inserts the most recently killed text in this package's own kill
+// ring at point and returns it. It does not read from or write to
+// Readline's own kill ring, the one C-y yanks from. It returns "" without
+// touching the line if this package's kill ring is empty.
+func GoYank() string {
+	if len(goKillRing) == 0 {
+		return ""
+	}
+	text := goKillRing[0]
+	c := C.CString(text)
+	C.rl_insert_text(c)
+	C.free(unsafe.Pointer(c))
+	return text
+}
+
+// ReverseSearchProvider, when set, takes over Ctrl-R from GNU Readline's
+// built-in substring search of the history. It is called with the query
+// typed so far and the currently selected mode, and returns matches with
+// the best match first. This lets tools such as shell history managers or
+// SQL REPLs filter by their own criteria (cwd, exit code, date range, ...)
+// instead of a plain substring match.
+var ReverseSearchProvider func(query string, mode int) []string
+
+// reverseSearchModes names the modes Tab cycles through during a
+// ReverseSearchProvider driven search.
+var reverseSearchModes []string
+
+// SetReverseSearchModes names the modes cycled through with Tab while a
+// ReverseSearchProvider search is in progress.
+func SetReverseSearchModes(modes []string) {
+	reverseSearchModes = modes
+}
+
+//export _go_reverse_search
+func _go_reverse_search(count, key C.int) C.int {
+	if ReverseSearchProvider == nil {
+		return C.rl_reverse_search_history(count, key)
+	}
+
+	mode := 0
+	query := ""
+	var matches []string
+
+	refresh := func() {
+		matches = ReverseSearchProvider(query, mode)
+		modeTag := ""
+		if len(reverseSearchModes) > 0 {
+			modeTag = reverseSearchModes[mode] + ":"
+		}
+		match := ""
+		if len(matches) > 0 {
+			match = matches[0]
+		}
+		msg := fmt.Sprintf("(reverse-i-search)%s%s: %s", modeTag, query, match)
+		m := C.CString(msg)
+		C.show_message(m)
+		C.free(unsafe.Pointer(m))
+	}
+	refresh()
+
+	for {
+		k := C.rl_read_key()
+		switch k {
+		case '\r', '\n':
+			C.rl_clear_message()
+			if len(matches) > 0 {
+				line := C.CString(matches[0])
+				C.rl_replace_line(line, 0)
+				C.free(unsafe.Pointer(line))
+				C.rl_point = C.rl_end
+			}
+			return 0
+		case 7, 27: // Ctrl-G or ESC cancels the search
+			C.rl_clear_message()
+			return 0
+		case -1: // EOF on the input stream: stop instead of busy-looping
+			C.rl_clear_message()
+			return 0
+		case '\t':
+			if len(reverseSearchModes) > 0 {
+				mode = (mode + 1) % len(reverseSearchModes)
+			}
+			refresh()
+		case 127, 8: // Backspace
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				refresh()
+			}
+		default:
+			if k >= 32 && k < 127 {
+				query += string(rune(k))
+				refresh()
+			}
+		}
+	}
+}
+
 // Add an item to the history.
 func AddHistory(s string) {
 	n := HistorySize()
@@ -237,6 +707,90 @@ func SaveHistory(path string) error {
 func Cleanup() {
 	C.rl_free_line_state()
 	C.rl_cleanup_after_signal()
+	if multiLineMode {
+		os.Stdout.WriteString(bracketedPasteOff)
+	}
+}
+
+const (
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	pasteBegin        = "\x1b[200~"
+	pasteEnd          = "\x1b[201~"
+)
+
+var multiLineMode bool
+
+// SetMultiLineMode enables multi-line editing, including bracketed-paste-safe
+// input: text pasted between the terminal's bracketed paste markers is
+// inserted as a single edit instead of being read keystroke by keystroke,
+// which would otherwise trigger a history entry or completion on every
+// newline embedded in the paste.
+func SetMultiLineMode(on bool) {
+	multiLineMode = on
+	name := C.CString("horizontal-scroll-mode")
+	value := C.CString("off")
+	C.rl_variable_bind(name, value)
+	C.free(unsafe.Pointer(name))
+	C.free(unsafe.Pointer(value))
+	if on {
+		C.install_paste_getc()
+		os.Stdout.WriteString(bracketedPasteOn)
+	} else {
+		C.restore_getc()
+		os.Stdout.WriteString(bracketedPasteOff)
+	}
+}
+
+// _go_getc stands in for GNU Readline's own character input function. It
+// passes characters through unchanged, except that it recognises the
+// terminal's bracketed paste markers, buffers everything between them, and
+// inserts the payload with a single rl_insert_text call.
+//
+//export _go_getc
+func _go_getc(stream *C.FILE) C.int {
+	c := C.rl_getc(stream)
+	if c < 0 || byte(c) != pasteBegin[0] {
+		return c
+	}
+	seen := []C.int{c}
+	for i := 1; i < len(pasteBegin); i++ {
+		c = C.rl_getc(stream)
+		seen = append(seen, c)
+		if c < 0 || byte(c) != pasteBegin[i] {
+			// Not a paste marker after all (e.g. an arrow key or other CSI
+			// sequence): push the rest back so readline still sees it in
+			// order, and return the first byte directly rather than
+			// reading a new one ahead of it.
+			for _, s := range seen[1:] {
+				C.rl_stuff_char(s)
+			}
+			return seen[0]
+		}
+	}
+	var payload []byte
+	var tail string
+	for {
+		c = C.rl_getc(stream)
+		if c < 0 {
+			break
+		}
+		payload = append(payload, byte(c))
+		tail += string(rune(byte(c)))
+		if len(tail) > len(pasteEnd) {
+			tail = tail[len(tail)-len(pasteEnd):]
+		}
+		if tail == pasteEnd {
+			payload = payload[:len(payload)-len(pasteEnd)]
+			break
+		}
+	}
+	if len(payload) > 0 {
+		p := C.CString(string(payload))
+		C.rl_insert_text(p)
+		C.free(unsafe.Pointer(p))
+	}
+	return C.rl_getc(stream)
 }
 
 // Returns a copy of s with all ANSI escape sequences surrounded by